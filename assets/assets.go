@@ -0,0 +1,358 @@
+// Package assets implements diecast's fingerprinted asset pipeline: it
+// rewrites configured static files (CSS, JS, images, ...) to
+// "name.<8charhash>.ext" so they can be served with long-lived cache headers,
+// and exposes the `asset` template function that resolves a logical path to
+// its fingerprinted URL.
+package assets
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ghetzel/diecast"
+	"github.com/ghodss/yaml"
+)
+
+// ManifestFilename is the default name of the asset manifest file written to
+// the root of the build destination.
+const ManifestFilename = `assets-manifest.json`
+
+// LongLivedCacheControl is the Cache-Control header value recorded in the
+// manifest for fingerprinted assets, suitable for a downstream CDN uploader
+// to apply: fingerprinted URLs are content-addressed, so they can be cached
+// forever.
+const LongLivedCacheControl = `public, max-age=31536000, immutable`
+
+// Entry describes a single fingerprinted asset.
+type Entry struct {
+	Source       string `json:"source"`
+	URL          string `json:"url"`
+	CacheControl string `json:"cacheControl,omitempty"`
+	Integrity    string `json:"integrity,omitempty"`
+}
+
+// Config controls which files the pipeline fingerprints and how.
+type Config struct {
+	// Patterns are shell globs (matched against a file's base name) that
+	// select which files get fingerprinted, e.g. "*.css", "*.js".
+	Patterns []string
+
+	// Root is the directory fingerprinted paths are resolved against, both
+	// when reading source files to hash and when writing fingerprinted
+	// copies during a build.
+	Root string
+
+	// SubresourceIntegrity, when true, also records a SHA-384 hash for each
+	// asset so the template function can emit an `integrity="sha384-..."`
+	// attribute.
+	SubresourceIntegrity bool
+
+	// ManifestPath is where ProcessDirectory reads the previous build's
+	// manifest from, to recognize fingerprinted copies it wrote on a prior
+	// run so they aren't mistaken for source files and re-fingerprinted. If
+	// empty, it defaults to "<Root>/assets-manifest.json".
+	ManifestPath string
+}
+
+// Pipeline resolves logical asset paths (e.g. "/css/site.css") to their
+// fingerprinted equivalents (e.g. "/css/site.a1b2c3d4.css"), computing and
+// caching hashes lazily.
+type Pipeline struct {
+	config  Config
+	mutex   sync.RWMutex
+	entries map[string]Entry
+
+	// reverse maps a fingerprinted URL back to the logical path it was
+	// derived from, so Wrap can serve it in live (non-build-site) mode.
+	reverse map[string]string
+}
+
+// New returns a Pipeline configured with config.
+func New(config Config) *Pipeline {
+	return &Pipeline{
+		config:  config,
+		entries: make(map[string]Entry),
+		reverse: make(map[string]string),
+	}
+}
+
+// Matches reports whether the given logical path should be fingerprinted
+// according to the pipeline's configured patterns.
+func (self *Pipeline) Matches(logicalPath string) bool {
+	base := filepath.Base(logicalPath)
+
+	for _, pattern := range self.config.Patterns {
+		if ok, _ := filepath.Match(pattern, base); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// URL resolves a logical asset path to its fingerprinted URL, computing and
+// caching the underlying file's hash on first use. It is the function
+// registered as the `asset` template helper.
+func (self *Pipeline) URL(logicalPath string) (string, error) {
+	entry, err := self.resolve(logicalPath)
+
+	if err != nil {
+		return ``, err
+	}
+
+	return entry.URL, nil
+}
+
+// Integrity resolves a logical asset path's subresource-integrity value
+// (e.g. "sha384-..."), returning an empty string if SubresourceIntegrity is
+// disabled.
+func (self *Pipeline) Integrity(logicalPath string) (string, error) {
+	entry, err := self.resolve(logicalPath)
+
+	if err != nil {
+		return ``, err
+	}
+
+	return entry.Integrity, nil
+}
+
+func (self *Pipeline) resolve(logicalPath string) (Entry, error) {
+	self.mutex.RLock()
+	entry, ok := self.entries[logicalPath]
+	self.mutex.RUnlock()
+
+	if ok {
+		return entry, nil
+	}
+
+	entry, err := self.fingerprint(logicalPath)
+
+	if err != nil {
+		return Entry{}, err
+	}
+
+	self.mutex.Lock()
+	self.entries[logicalPath] = entry
+	self.reverse[entry.URL] = logicalPath
+	self.mutex.Unlock()
+
+	return entry, nil
+}
+
+// Wrap returns a handler that serves a fingerprinted URL directly from its
+// source file with a long-lived Cache-Control header, falling through to
+// next for any path that isn't a known fingerprinted asset. This is what
+// lets `{{ asset ... }}` links resolve in live serve mode, where (unlike a
+// `--build-site` run) no fingerprinted copy is ever written to disk.
+func (self *Pipeline) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		self.mutex.RLock()
+		logicalPath, ok := self.reverse[r.URL.Path]
+		self.mutex.RUnlock()
+
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set(`Cache-Control`, LongLivedCacheControl)
+		http.ServeFile(w, r, filepath.Join(self.config.Root, logicalPath))
+	})
+}
+
+func (self *Pipeline) fingerprint(logicalPath string) (Entry, error) {
+	data, err := ioutil.ReadFile(filepath.Join(self.config.Root, logicalPath))
+
+	if err != nil {
+		return Entry{}, err
+	}
+
+	hash := sha256.Sum256(data)
+	fingerprint := hex.EncodeToString(hash[:])[:8]
+
+	ext := filepath.Ext(logicalPath)
+	base := strings.TrimSuffix(logicalPath, ext)
+	fingerprinted := fmt.Sprintf("%s.%s%s", base, fingerprint, ext)
+
+	entry := Entry{
+		Source:       logicalPath,
+		URL:          fingerprinted,
+		CacheControl: LongLivedCacheControl,
+	}
+
+	if self.config.SubresourceIntegrity {
+		sum := sha512.Sum384(data)
+		entry.Integrity = `sha384-` + base64.StdEncoding.EncodeToString(sum[:])
+	}
+
+	return entry, nil
+}
+
+// Manifest is the fingerprinted-asset manifest written alongside a static
+// build, recording each asset's fingerprinted URL, cache header, and
+// optional integrity hash for a downstream CDN uploader.
+type Manifest struct {
+	Assets map[string]Entry `json:"assets"`
+}
+
+// WriteTo serializes the manifest as indented JSON to the given path.
+func (self *Manifest) WriteTo(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(self, ``, `  `)
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// LoadManifest reads a manifest from the given path. A missing file is not
+// an error; it results in an empty manifest.
+func LoadManifest(path string) (*Manifest, error) {
+	manifest := &Manifest{
+		Assets: make(map[string]Entry),
+	}
+
+	if data, err := ioutil.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, manifest); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if manifest.Assets == nil {
+		manifest.Assets = make(map[string]Entry)
+	}
+
+	return manifest, nil
+}
+
+// ProcessDirectory walks root for files matching the pipeline's configured
+// patterns, writes a fingerprinted copy of each one alongside the original,
+// and returns a Manifest describing what was produced. It first loads the
+// previous manifest (see Config.ManifestPath) and skips any path already
+// recorded there as a fingerprinted URL, so that a fingerprinted copy from
+// an earlier run is never mistaken for a source file and re-fingerprinted
+// on top of itself.
+func (self *Pipeline) ProcessDirectory(root string) (*Manifest, error) {
+	manifestPath := self.config.ManifestPath
+
+	if manifestPath == `` {
+		manifestPath = filepath.Join(root, ManifestFilename)
+	}
+
+	previous, err := LoadManifest(manifestPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	generated := make(map[string]bool, len(previous.Assets))
+
+	for _, entry := range previous.Assets {
+		generated[entry.URL] = true
+	}
+
+	manifest := &Manifest{
+		Assets: make(map[string]Entry),
+	}
+
+	self.config.Root = root
+
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		logicalPath := strings.TrimPrefix(strings.TrimPrefix(path, root), `/`)
+		logicalPath = `/` + logicalPath
+
+		if generated[logicalPath] {
+			return nil
+		}
+
+		if !self.Matches(logicalPath) {
+			return nil
+		}
+
+		entry, err := self.fingerprint(logicalPath)
+
+		if err != nil {
+			return err
+		}
+
+		data, err := ioutil.ReadFile(path)
+
+		if err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(root, entry.URL), data, 0644); err != nil {
+			return err
+		}
+
+		manifest.Assets[logicalPath] = entry
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// Register installs the `asset` (and, when integrity is enabled,
+// `assetIntegrity`) template functions into funcs, backed by pipeline.
+func Register(funcs diecast.FuncMap, pipeline *Pipeline) {
+	funcs[`asset`] = pipeline.URL
+
+	if pipeline.config.SubresourceIntegrity {
+		funcs[`assetIntegrity`] = pipeline.Integrity
+	}
+}
+
+// ConfigFile is the `assets:` section of diecast.yml.
+type ConfigFile struct {
+	Patterns             []string `json:"patterns"`
+	SubresourceIntegrity bool     `json:"subresource_integrity"`
+	Manifest             string   `json:"manifest"`
+}
+
+type rootConfigFile struct {
+	Assets ConfigFile `json:"assets"`
+}
+
+// LoadConfigFile reads the `assets:` section out of a diecast.yml-formatted
+// file. A missing file or empty section is not an error.
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return &ConfigFile{}, nil
+	}
+
+	var root rootConfigFile
+
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	return &root.Assets, nil
+}
@@ -0,0 +1,172 @@
+package assets
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPipelineWrapServesFingerprintedURL(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `assets-test-`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, `site.css`), []byte(`body{}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pipeline := New(Config{
+		Patterns: []string{`*.css`},
+		Root:     dir,
+	})
+
+	fingerprinted, err := pipeline.URL(`/site.css`)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var fellThrough bool
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fellThrough = true
+	})
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, fingerprinted, nil)
+
+	pipeline.Wrap(next).ServeHTTP(recorder, request)
+
+	if fellThrough {
+		t.Fatal("expected Wrap to serve the fingerprinted asset itself, not fall through")
+	}
+
+	if recorder.Body.String() != `body{}` {
+		t.Fatalf("expected the source file's contents, got %q", recorder.Body.String())
+	}
+
+	if recorder.Header().Get(`Cache-Control`) != LongLivedCacheControl {
+		t.Fatalf("expected a long-lived Cache-Control header, got %q", recorder.Header().Get(`Cache-Control`))
+	}
+}
+
+func TestPipelineWrapFallsThroughForUnknownPaths(t *testing.T) {
+	pipeline := New(Config{Patterns: []string{`*.css`}})
+
+	var fellThrough bool
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fellThrough = true
+	})
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, `/unrelated.html`, nil)
+
+	pipeline.Wrap(next).ServeHTTP(recorder, request)
+
+	if !fellThrough {
+		t.Fatal("expected Wrap to fall through for a path it doesn't know about")
+	}
+}
+
+func TestProcessDirectorySkipsPreviouslyGeneratedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `assets-test-`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, `site.css`), []byte(`body{}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manifestPath := filepath.Join(dir, ManifestFilename)
+
+	newPipeline := func() *Pipeline {
+		return New(Config{
+			Patterns:     []string{`*.css`},
+			Root:         dir,
+			ManifestPath: manifestPath,
+		})
+	}
+
+	for i := 0; i < 3; i++ {
+		manifest, err := newPipeline().ProcessDirectory(dir)
+
+		if err != nil {
+			t.Fatalf("run %d: unexpected error: %v", i, err)
+		}
+
+		if len(manifest.Assets) != 1 {
+			t.Fatalf("run %d: expected exactly one asset, got %+v", i, manifest.Assets)
+		}
+
+		entry, ok := manifest.Assets[`/site.css`]
+
+		if !ok {
+			t.Fatalf("run %d: expected /site.css to be recorded, got %+v", i, manifest.Assets)
+		}
+
+		if strings.Count(entry.URL, `.`) != 2 {
+			t.Fatalf("run %d: expected a single fingerprint segment, got %v", i, entry.URL)
+		}
+
+		if err := manifest.WriteTo(manifestPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := ioutil.ReadDir(dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cssFiles := 0
+
+	for _, file := range files {
+		if filepath.Ext(file.Name()) == `.css` {
+			cssFiles++
+		}
+	}
+
+	if cssFiles != 2 {
+		t.Fatalf("expected exactly 2 .css files (source + one fingerprinted copy), got %d", cssFiles)
+	}
+}
+
+func TestManifestWriteTo(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `assets-test-`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	manifest := &Manifest{
+		Assets: map[string]Entry{
+			`/site.css`: {Source: `/site.css`, URL: `/site.a1b2c3d4.css`},
+		},
+	}
+
+	path := filepath.Join(dir, `nested`, ManifestFilename)
+
+	if err := manifest.WriteTo(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected manifest file to be written: %v", err)
+	}
+}
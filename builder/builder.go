@@ -0,0 +1,435 @@
+// Package builder implements the parallel, incremental static site builder
+// used by `diecast --build-site`. It fans requests for a known set of URLs
+// out across a worker pool, hashes the rendered response bodies, and
+// compares those hashes against a manifest left behind by the previous
+// build so that unchanged output is never rewritten.
+package builder
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/ghetzel/diecast/debuglog"
+	"github.com/ghodss/yaml"
+)
+
+var log = debuglog.Get(`build`)
+
+// ManifestFilename is the default name of the manifest file written to the
+// root of the build destination.
+const ManifestFilename = `manifest.json`
+
+// HashAlgorithm identifies which hash function the builder uses to fingerprint
+// rendered response bodies.
+type HashAlgorithm string
+
+const (
+	// HashSHA256 hashes response bodies with SHA-256 (the default).
+	HashSHA256 HashAlgorithm = `sha256`
+
+	// HashXXHash hashes response bodies with xxhash.
+	HashXXHash HashAlgorithm = `xxhash`
+)
+
+// Entry describes a single file produced by a build, as recorded in the
+// manifest.
+type Entry struct {
+	Path        string    `json:"path"`
+	Hash        string    `json:"hash"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"contentType"`
+	Mtime       time.Time `json:"mtime"`
+}
+
+// Manifest is the set of files produced by a build, keyed by destination
+// path, along with the hash algorithm used to produce it.
+type Manifest struct {
+	Algorithm HashAlgorithm    `json:"algorithm"`
+	Files     map[string]Entry `json:"files"`
+}
+
+// LoadManifest reads a manifest from the given path. A missing file is not
+// an error; it results in an empty manifest, as on a first build.
+func LoadManifest(path string) (*Manifest, error) {
+	manifest := &Manifest{
+		Files: make(map[string]Entry),
+	}
+
+	if data, err := ioutil.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, manifest); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if manifest.Files == nil {
+		manifest.Files = make(map[string]Entry)
+	}
+
+	return manifest, nil
+}
+
+// WriteTo serializes the manifest as indented JSON to the given path.
+func (self *Manifest) WriteTo(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(self, ``, `  `)
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// Result summarizes the outcome of a single build run.
+type Result struct {
+	Built   int
+	Skipped int
+	Removed int
+}
+
+// Config controls how a Builder renders a site.
+type Config struct {
+	// Concurrency is the number of worker goroutines fetching and writing
+	// pages concurrently. If zero, runtime.GOMAXPROCS(0) is used.
+	Concurrency int
+
+	// Algorithm is the hash function used to fingerprint rendered bodies.
+	Algorithm HashAlgorithm
+
+	// ManifestPath is where the build manifest is read from and written to.
+	// If empty, it defaults to "<Destination>/manifest.json".
+	ManifestPath string
+
+	// Destination is the root directory the site is rendered into.
+	Destination string
+
+	// BaseURL is the scheme+host (e.g. "http://127.0.0.1:8080") the builder
+	// issues requests against for each path.
+	BaseURL string
+
+	// Client performs the actual HTTP requests. If nil, a client with a
+	// 10 second timeout is used.
+	Client *http.Client
+}
+
+// ConfigFile is the `build:` section of diecast.yml.
+type ConfigFile struct {
+	Concurrency int           `json:"concurrency"`
+	Algorithm   HashAlgorithm `json:"hash_algorithm"`
+	Manifest    string        `json:"manifest"`
+}
+
+type rootConfigFile struct {
+	Build ConfigFile `json:"build"`
+}
+
+// LoadConfigFile reads the `build:` section out of a diecast.yml-formatted
+// file. A missing file or empty section is not an error.
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return &ConfigFile{}, nil
+	}
+
+	var root rootConfigFile
+
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	return &root.Build, nil
+}
+
+// Builder renders a known set of URL paths from a running diecast server
+// into static files on disk, skipping any file whose content hash matches
+// the previous build's manifest.
+type Builder struct {
+	config Config
+}
+
+// New returns a Builder configured with the given Config, applying defaults
+// for any zero-valued fields.
+func New(config Config) *Builder {
+	if config.Concurrency <= 0 {
+		config.Concurrency = runtime.GOMAXPROCS(0)
+	}
+
+	if config.Algorithm == `` {
+		config.Algorithm = HashSHA256
+	}
+
+	if config.ManifestPath == `` {
+		config.ManifestPath = filepath.Join(config.Destination, ManifestFilename)
+	}
+
+	if config.Client == nil {
+		config.Client = &http.Client{
+			Timeout: 10 * time.Second,
+		}
+	}
+
+	return &Builder{
+		config: config,
+	}
+}
+
+type fetchResult struct {
+	path      string
+	err       error
+	skip      bool
+	cancelled bool
+}
+
+// Build renders the given URL paths, writing changed files beneath the
+// destination directory and removing any previously-built file that is no
+// longer present in paths. It returns a summary of what happened.
+//
+// If any path fails to render, in-flight workers are allowed to finish but
+// no new work is dispatched, and the manifest is written with whatever
+// succeeded before the failure so the next run can resume from there
+// instead of redoing completed work. Stale-file pruning is skipped in that
+// case, since the current build is known to be incomplete.
+func (self *Builder) Build(paths []string) (*Result, error) {
+	previous, err := LoadManifest(self.config.ManifestPath)
+
+	if err != nil {
+		return nil, fmt.Errorf("load manifest: %v", err)
+	}
+
+	current := &Manifest{
+		Algorithm: self.config.Algorithm,
+		Files:     make(map[string]Entry),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	jobs := make(chan string)
+	results := make(chan fetchResult, len(paths))
+
+	for w := 0; w < self.config.Concurrency; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for path := range jobs {
+				select {
+				case <-ctx.Done():
+					results <- fetchResult{path: path, cancelled: true}
+					continue
+				default:
+				}
+
+				entry, skipped, err := self.renderOne(path, previous)
+
+				if err == nil && !skipped {
+					mutex.Lock()
+					current.Files[path] = *entry
+					mutex.Unlock()
+				} else if err == nil && skipped {
+					mutex.Lock()
+					current.Files[path] = previous.Files[path]
+					mutex.Unlock()
+				}
+
+				results <- fetchResult{
+					path: path,
+					err:  err,
+					skip: skipped,
+				}
+			}
+		}()
+	}
+
+	go func() {
+		for _, path := range paths {
+			select {
+			case jobs <- path:
+			case <-ctx.Done():
+			}
+		}
+
+		close(jobs)
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	result := &Result{}
+	var firstErr error
+
+	for r := range results {
+		if r.cancelled {
+			continue
+		} else if r.err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("render %v: %v", r.path, r.err)
+				cancel()
+			}
+		} else if r.skip {
+			result.Skipped++
+			log.Debugf("unchanged, skipping: %v", r.path)
+		} else {
+			result.Built++
+			log.Debugf("wrote: %v", r.path)
+		}
+	}
+
+	if firstErr == nil {
+		removed, err := self.pruneStale(previous, current)
+
+		if err != nil {
+			return nil, fmt.Errorf("prune: %v", err)
+		}
+
+		result.Removed = removed
+	}
+
+	if err := current.WriteTo(self.config.ManifestPath); err != nil {
+		if firstErr == nil {
+			return nil, fmt.Errorf("write manifest: %v", err)
+		}
+
+		log.Errorf("write manifest after build error: %v", err)
+	}
+
+	if firstErr != nil {
+		return result, firstErr
+	}
+
+	log.Debugf("current manifest: %v", current.sortedPaths())
+	log.Infof("build complete: %d built, %d skipped, %d removed", result.Built, result.Skipped, result.Removed)
+
+	return result, nil
+}
+
+// renderOne fetches a single path, hashes the body, and writes it to disk
+// unless the hash matches the previous manifest entry for that path.
+func (self *Builder) renderOne(path string, previous *Manifest) (*Entry, bool, error) {
+	response, err := self.config.Client.Get(self.config.BaseURL + path)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode >= 400 {
+		return nil, false, fmt.Errorf("%v", response.Status)
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	hash := hashBody(self.config.Algorithm, body)
+	destFile := filepath.Join(self.config.Destination, path)
+
+	entry := &Entry{
+		Path:        path,
+		Hash:        hash,
+		Size:        int64(len(body)),
+		ContentType: response.Header.Get(`Content-Type`),
+		Mtime:       time.Now(),
+	}
+
+	if prior, ok := previous.Files[path]; ok && prior.Hash == hash {
+		if _, err := os.Stat(destFile); err == nil {
+			return entry, true, nil
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(destFile), 0755); err != nil {
+		return nil, false, err
+	}
+
+	file, err := os.Create(destFile)
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	defer file.Close()
+
+	if _, err := io.Copy(file, bytes.NewReader(body)); err != nil {
+		return nil, false, err
+	}
+
+	return entry, false, nil
+}
+
+// pruneStale removes files that were produced by the previous build but are
+// not present in the current one.
+func (self *Builder) pruneStale(previous *Manifest, current *Manifest) (int, error) {
+	removed := 0
+
+	for path := range previous.Files {
+		if _, ok := current.Files[path]; ok {
+			continue
+		}
+
+		destFile := filepath.Join(self.config.Destination, path)
+
+		if err := os.Remove(destFile); err == nil {
+			removed++
+			log.Debugf("removed stale file: %v", path)
+		} else if !os.IsNotExist(err) {
+			return removed, err
+		}
+	}
+
+	return removed, nil
+}
+
+func hashBody(algorithm HashAlgorithm, body []byte) string {
+	switch algorithm {
+	case HashXXHash:
+		sum := xxhash.Sum64(body)
+		return fmt.Sprintf("%016x", sum)
+	default:
+		sum := sha256.Sum256(body)
+		return hex.EncodeToString(sum[:])
+	}
+}
+
+// sortedPaths returns the manifest's file paths in sorted order, which is
+// useful for deterministic summaries and diffs between two manifests.
+func (self *Manifest) sortedPaths() []string {
+	paths := make([]string, 0, len(self.Files))
+
+	for path := range self.Files {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+
+	return paths
+}
@@ -0,0 +1,135 @@
+package builder
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHashBodyDeterministic(t *testing.T) {
+	body := []byte(`hello world`)
+
+	if hashBody(HashSHA256, body) != hashBody(HashSHA256, body) {
+		t.Fatal("sha256 hash should be deterministic")
+	}
+
+	if hashBody(HashXXHash, body) != hashBody(HashXXHash, body) {
+		t.Fatal("xxhash hash should be deterministic")
+	}
+
+	if hashBody(HashSHA256, body) == hashBody(HashXXHash, body) {
+		t.Fatal("sha256 and xxhash should not collide on the same input")
+	}
+}
+
+func TestLoadManifestMissingFile(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `builder-test-`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	manifest, err := LoadManifest(filepath.Join(dir, `manifest.json`))
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(manifest.Files) != 0 {
+		t.Fatalf("expected empty manifest, got %d files", len(manifest.Files))
+	}
+}
+
+func TestBuildSkipsUnchangedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `builder-test-`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`unchanged`))
+	}))
+
+	defer server.Close()
+
+	b := New(Config{
+		Concurrency: 2,
+		Destination: dir,
+		BaseURL:     server.URL,
+	})
+
+	result, err := b.Build([]string{`/a`, `/b`})
+
+	if err != nil {
+		t.Fatalf("first build failed: %v", err)
+	}
+
+	if result.Built != 2 || result.Skipped != 0 {
+		t.Fatalf("expected 2 built, 0 skipped on first run; got %+v", result)
+	}
+
+	result, err = b.Build([]string{`/a`, `/b`})
+
+	if err != nil {
+		t.Fatalf("second build failed: %v", err)
+	}
+
+	if result.Built != 0 || result.Skipped != 2 {
+		t.Fatalf("expected 0 built, 2 skipped on second run; got %+v", result)
+	}
+}
+
+func TestBuildPersistsPartialManifestOnError(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `builder-test-`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == `/bad` {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Write([]byte(`ok`))
+	}))
+
+	defer server.Close()
+
+	b := New(Config{
+		Concurrency: 1,
+		Destination: dir,
+		BaseURL:     server.URL,
+	})
+
+	_, err = b.Build([]string{`/good`, `/bad`})
+
+	if err == nil {
+		t.Fatal("expected an error from the failing path")
+	}
+
+	manifest, err := LoadManifest(filepath.Join(dir, ManifestFilename))
+
+	if err != nil {
+		t.Fatalf("failed to load manifest after failed build: %v", err)
+	}
+
+	if _, ok := manifest.Files[`/good`]; !ok {
+		t.Fatal("expected the successfully-rendered path to be recorded in the manifest")
+	}
+
+	if _, ok := manifest.Files[`/bad`]; ok {
+		t.Fatal("did not expect the failed path to be recorded in the manifest")
+	}
+}
@@ -0,0 +1,131 @@
+// Package debuglog is a thin façade over the existing go-logging logger that
+// tags every message with a component name (e.g. "server", "mount.proxy",
+// "binding") and gates Debug-level calls behind the DEBUG environment
+// variable. The overall --log-level floor still applies on top of it: a
+// component enabled by DEBUG prints debug messages only when the log level
+// admits them.
+//
+// DEBUG is a comma-separated list of filepath.Match-style glob patterns
+// matched against a component name, e.g. DEBUG="mount.*,binding" or
+// DEBUG="*". A pattern prefixed with "-" negates, excluding components that
+// would otherwise match, e.g. DEBUG="*,-template" enables everything except
+// the "template" component.
+package debuglog
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/op/go-logging"
+)
+
+var (
+	mutex    sync.RWMutex
+	patterns []string
+)
+
+func init() {
+	SetFilter(os.Getenv(`DEBUG`))
+}
+
+// SetFilter replaces the active DEBUG filter with the comma-separated list
+// of glob patterns in spec. It is safe to call concurrently with Get'd
+// Loggers.
+func SetFilter(spec string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	patterns = patterns[:0]
+
+	for _, pattern := range strings.Split(spec, `,`) {
+		if pattern = strings.TrimSpace(pattern); pattern != `` {
+			patterns = append(patterns, pattern)
+		}
+	}
+}
+
+// Enabled reports whether debug-level logging is currently enabled for the
+// given component name.
+func Enabled(component string) bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	enabled := false
+
+	for _, pattern := range patterns {
+		negate := strings.HasPrefix(pattern, `-`)
+		glob := strings.TrimPrefix(pattern, `-`)
+
+		if ok, _ := filepath.Match(glob, component); ok {
+			enabled = !negate
+		}
+	}
+
+	return enabled
+}
+
+// Logger is a component-tagged handle onto the underlying go-logging
+// logger. Its Debug/Debugf calls are no-ops unless the DEBUG filter enables
+// this Logger's component; all other levels always pass through.
+type Logger struct {
+	component string
+	backend   *logging.Logger
+}
+
+// Get returns a Logger tagged with the given component name, backed by the
+// go-logging module of the same name.
+func Get(component string) *Logger {
+	return &Logger{
+		component: component,
+		backend:   logging.MustGetLogger(component),
+	}
+}
+
+// Debug logs at debug level if this Logger's component is enabled by DEBUG.
+func (self *Logger) Debug(args ...interface{}) {
+	if Enabled(self.component) {
+		self.backend.Debug(args...)
+	}
+}
+
+// Debugf logs at debug level if this Logger's component is enabled by
+// DEBUG.
+func (self *Logger) Debugf(format string, args ...interface{}) {
+	if Enabled(self.component) {
+		self.backend.Debugf(format, args...)
+	}
+}
+
+func (self *Logger) Info(args ...interface{}) {
+	self.backend.Info(args...)
+}
+
+func (self *Logger) Infof(format string, args ...interface{}) {
+	self.backend.Infof(format, args...)
+}
+
+func (self *Logger) Warning(args ...interface{}) {
+	self.backend.Warning(args...)
+}
+
+func (self *Logger) Warningf(format string, args ...interface{}) {
+	self.backend.Warningf(format, args...)
+}
+
+func (self *Logger) Error(args ...interface{}) {
+	self.backend.Error(args...)
+}
+
+func (self *Logger) Errorf(format string, args ...interface{}) {
+	self.backend.Errorf(format, args...)
+}
+
+func (self *Logger) Fatal(args ...interface{}) {
+	self.backend.Fatal(args...)
+}
+
+func (self *Logger) Fatalf(format string, args ...interface{}) {
+	self.backend.Fatalf(format, args...)
+}
@@ -0,0 +1,73 @@
+package debuglog
+
+import "testing"
+
+func TestEnabledNoFilter(t *testing.T) {
+	SetFilter(``)
+
+	if Enabled(`template`) {
+		t.Fatal("expected no components to be enabled with an empty filter")
+	}
+}
+
+func TestEnabledWildcard(t *testing.T) {
+	SetFilter(`*`)
+
+	if !Enabled(`template`) {
+		t.Fatal("expected * to enable every component")
+	}
+
+	if !Enabled(`mount.proxy`) {
+		t.Fatal("expected * to enable every component")
+	}
+}
+
+func TestEnabledExactMatch(t *testing.T) {
+	SetFilter(`binding`)
+
+	if !Enabled(`binding`) {
+		t.Fatal("expected an exact match to be enabled")
+	}
+
+	if Enabled(`template`) {
+		t.Fatal("expected a non-matching component to stay disabled")
+	}
+}
+
+func TestEnabledGlobMatch(t *testing.T) {
+	SetFilter(`mount.*`)
+
+	if !Enabled(`mount.proxy`) {
+		t.Fatal("expected mount.* to match mount.proxy")
+	}
+
+	if Enabled(`binding`) {
+		t.Fatal("expected mount.* to not match binding")
+	}
+}
+
+func TestEnabledNegation(t *testing.T) {
+	SetFilter(`*,-template`)
+
+	if Enabled(`template`) {
+		t.Fatal("expected -template to exclude the template component")
+	}
+
+	if !Enabled(`binding`) {
+		t.Fatal("expected every other component to stay enabled")
+	}
+}
+
+func TestEnabledLastMatchWins(t *testing.T) {
+	SetFilter(`template,-template`)
+
+	if Enabled(`template`) {
+		t.Fatal("expected the later -template pattern to win")
+	}
+
+	SetFilter(`-template,template`)
+
+	if !Enabled(`template`) {
+		t.Fatal("expected the later template pattern to win")
+	}
+}
@@ -1,24 +1,33 @@
 package main
 
 import (
-	"fmt"
-	"io"
+	"context"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"sort"
 	"strings"
+	"syscall"
 	"time"
 
 	"path/filepath"
 
 	"github.com/ghetzel/cli"
 	"github.com/ghetzel/diecast"
+	"github.com/ghetzel/diecast/assets"
+	"github.com/ghetzel/diecast/builder"
+	"github.com/ghetzel/diecast/debuglog"
+	"github.com/ghetzel/diecast/fdlisten"
+	"github.com/ghetzel/diecast/feeds"
+	"github.com/ghetzel/diecast/tlsutil"
 	"github.com/ghetzel/diecast/util"
 	"github.com/ghetzel/go-stockutil/sliceutil"
 	"github.com/op/go-logging"
 )
 
-var log = logging.MustGetLogger(`main`)
+var log = debuglog.Get(`main`)
 
 func main() {
 	app := cli.NewApp()
@@ -91,6 +100,70 @@ func main() {
 			Usage: `The destination directory to put files in when rendering a static site.`,
 			Value: `./_site`,
 		},
+		cli.IntFlag{
+			Name:  `build-concurrency`,
+			Usage: `The number of concurrent workers used to render a static site. Defaults to GOMAXPROCS.`,
+		},
+		cli.StringFlag{
+			Name:  `build-hash-algorithm`,
+			Usage: `The hash algorithm (sha256 or xxhash) used to fingerprint rendered pages for incremental builds.`,
+			Value: string(builder.HashSHA256),
+		},
+		cli.StringFlag{
+			Name:  `build-manifest`,
+			Usage: `The path to the build manifest, relative to the build destination. Defaults to "manifest.json".`,
+			Value: builder.ManifestFilename,
+		},
+		cli.BoolFlag{
+			Name:  `tls`,
+			Usage: `Serve HTTPS instead of plain HTTP.`,
+		},
+		cli.StringFlag{
+			Name:  `tls-mode`,
+			Usage: `The TLS mode to use: "static", "autocert", or "self_signed".`,
+			Value: string(tlsutil.ModeStatic),
+		},
+		cli.StringFlag{
+			Name:  `tls-cert-file`,
+			Usage: `The certificate file to use in "static" TLS mode.`,
+		},
+		cli.StringFlag{
+			Name:  `tls-key-file`,
+			Usage: `The key file to use in "static" TLS mode.`,
+		},
+		cli.StringFlag{
+			Name:  `tls-cache-dir`,
+			Usage: `Where to persist issued certificates in "autocert" TLS mode.`,
+		},
+		cli.StringSliceFlag{
+			Name:  `tls-host`,
+			Usage: `A hostname this server is allowed to request a certificate for in "autocert" TLS mode. May be specified multiple times.`,
+		},
+		cli.StringFlag{
+			Name:  `tls-email`,
+			Usage: `The contact email given to the ACME CA in "autocert" TLS mode.`,
+		},
+		cli.BoolFlag{
+			Name:  `tls-http-redirect`,
+			Usage: `Also listen on :80 and redirect all requests to https.`,
+		},
+		cli.StringFlag{
+			Name:  `listen-fd-name`,
+			Usage: `The name of the systemd socket-activation fd to adopt, looked up in place of the default role name ("http", or "https" with --tls). When exactly one fd is passed, it is adopted whatever its name and whatever this flag says.`,
+		},
+		cli.StringSliceFlag{
+			Name:  `asset-pattern`,
+			Usage: `A shell glob pattern (matched against a file's base name, e.g. "*.css") selecting files to fingerprint. May be specified multiple times; enables the "asset" template function.`,
+		},
+		cli.BoolFlag{
+			Name:  `asset-subresource-integrity`,
+			Usage: `Also record SHA-384 subresource-integrity hashes for fingerprinted assets.`,
+		},
+		cli.StringFlag{
+			Name:  `asset-manifest`,
+			Usage: `The path to the asset manifest, relative to the build destination. Defaults to "assets-manifest.json".`,
+			Value: assets.ManifestFilename,
+		},
 	}
 
 	app.Before = func(c *cli.Context) error {
@@ -102,6 +175,7 @@ func main() {
 
 		logging.SetFormatter(logging.MustStringFormatter(`%{color}%{level:.4s}%{color:reset}[%{id:04d}] %{module}: %{message}`))
 		logging.SetLevel(level, ``)
+		debuglog.SetFilter(os.Getenv(`DEBUG`))
 
 		return nil
 	}
@@ -123,6 +197,42 @@ func main() {
 
 		server.TemplatePatterns = append(server.TemplatePatterns, c.StringSlice(`template-pattern`)...)
 
+		assetConfig, err := assets.LoadConfigFile(c.String(`config`))
+
+		if err != nil {
+			log.Fatalf("asset config error: %v", err)
+		}
+
+		assetPatterns := assetConfig.Patterns
+
+		if c.IsSet(`asset-pattern`) || len(assetPatterns) == 0 {
+			assetPatterns = c.StringSlice(`asset-pattern`)
+		}
+
+		assetSRI := assetConfig.SubresourceIntegrity
+
+		if c.IsSet(`asset-subresource-integrity`) {
+			assetSRI = c.Bool(`asset-subresource-integrity`)
+		}
+
+		assetManifestName := assetConfig.Manifest
+
+		if c.IsSet(`asset-manifest`) || assetManifestName == `` {
+			assetManifestName = c.String(`asset-manifest`)
+		}
+
+		var assetPipeline *assets.Pipeline
+
+		if len(assetPatterns) > 0 {
+			assetPipeline = assets.New(assets.Config{
+				Patterns:             assetPatterns,
+				Root:                 servePath,
+				SubresourceIntegrity: assetSRI,
+			})
+
+			assets.Register(diecast.GetStandardFunctions(), assetPipeline)
+		}
+
 		mounts := make([]diecast.Mount, 0)
 
 		for i, mountSpec := range c.StringSlice(`mount`) {
@@ -150,18 +260,53 @@ func main() {
 			log.Debugf("mount %T: %+v", mount, mount)
 		}
 
-		if err := server.Initialize(); err == nil {
-			log.Infof("Starting HTTP server at http://%s", server.Address)
+		// In live serve mode, fingerprinted asset URLs never exist on disk
+		// (fingerprinted copies are only written by --build-site), so wrap
+		// the server's handler with one that resolves them back to their
+		// source file before falling through to the server's own mounts.
+		var handler http.Handler = server
+
+		if assetPipeline != nil {
+			handler = assetPipeline.Wrap(handler)
+		}
 
-			go func() {
-				if err := server.Serve(); err != nil {
-					log.Fatal(err)
+		if err := server.Initialize(); err == nil {
+			if c.Bool(`tls`) {
+				if err := serveTLS(c, server, handler); err != nil {
+					log.Fatalf("Failed to start HTTPS server: %v", err)
 				}
-			}()
+			} else if listener, ok, err := fdlisten.Listener(fdlistenName(c, `http`)); err == nil && ok {
+				log.Infof("Starting HTTP server at http://%s (adopted from systemd listen-fd)", server.Address)
+
+				go func() {
+					if err := http.Serve(listener, handler); err != nil {
+						log.Fatal(err)
+					}
+				}()
+			} else if err != nil {
+				log.Fatalf("Failed to adopt listen-fd: %v", err)
+			} else if assetPipeline != nil {
+				log.Infof("Starting HTTP server at http://%s", server.Address)
+
+				go func() {
+					if err := http.ListenAndServe(server.Address, handler); err != nil {
+						log.Fatal(err)
+					}
+				}()
+			} else {
+				log.Infof("Starting HTTP server at http://%s", server.Address)
+
+				go func() {
+					if err := server.Serve(); err != nil {
+						log.Fatal(err)
+					}
+				}()
+			}
 
 			if c.Bool(`build-site`) {
 				log.Infof("Rendering site in %v", servePath)
 				paths := make([]string, 0)
+				sourcePaths := make(map[string]string)
 
 				if err := filepath.Walk(servePath, func(path string, info os.FileInfo, err error) error {
 					base := filepath.Base(path)
@@ -180,6 +325,10 @@ func main() {
 
 						if !sliceutil.ContainsString(paths, urlPath) {
 							paths = append(paths, urlPath)
+
+							relSource := strings.TrimPrefix(path, servePath)
+							relSource = strings.TrimPrefix(relSource, `/`)
+							sourcePaths[urlPath] = relSource
 						}
 					}
 
@@ -189,43 +338,70 @@ func main() {
 				}
 
 				destinationPath := c.String(`build-destination`)
+				sort.Strings(paths)
 
-				if err := os.RemoveAll(destinationPath); err != nil {
-					log.Fatalf("Failed to cleanup destination: %v", err)
+				buildConfig, err := builder.LoadConfigFile(c.String(`config`))
+
+				if err != nil {
+					log.Fatalf("build config error: %v", err)
 				}
 
-				sort.Strings(paths)
-				client := &http.Client{
-					Timeout: time.Duration(10) * time.Second,
+				concurrency := buildConfig.Concurrency
+
+				if c.IsSet(`build-concurrency`) || concurrency <= 0 {
+					concurrency = c.Int(`build-concurrency`)
 				}
 
-				for _, path := range paths {
-					response, err := client.Get(`http://` + server.Address + path)
+				algorithm := buildConfig.Algorithm
 
-					if err == nil && response.StatusCode >= 400 {
-						err = fmt.Errorf("%v", response.Status)
-					}
+				if c.IsSet(`build-hash-algorithm`) || algorithm == `` {
+					algorithm = builder.HashAlgorithm(c.String(`build-hash-algorithm`))
+				}
 
-					if err == nil {
-						destFile := filepath.Join(destinationPath, path)
+				manifestName := buildConfig.Manifest
 
-						if err := os.MkdirAll(filepath.Dir(destFile), 0755); err != nil {
-							log.Fatalf("Failed to create destination: %v", err)
-						}
+				if c.IsSet(`build-manifest`) || manifestName == `` {
+					manifestName = c.String(`build-manifest`)
+				}
+
+				scheme := `http`
 
-						if file, err := os.Create(destFile); err == nil {
-							_, err := io.Copy(file, response.Body)
+				if c.Bool(`tls`) {
+					scheme = `https`
+				}
 
-							if err != nil {
-								log.Fatalf("Failed to write file %v: %v", destFile, err)
-							}
+				site := builder.New(builder.Config{
+					Concurrency:  concurrency,
+					Algorithm:    algorithm,
+					ManifestPath: filepath.Join(destinationPath, manifestName),
+					Destination:  destinationPath,
+					BaseURL:      scheme + `://` + server.Address,
+				})
 
-							file.Close()
-						} else {
-							log.Fatalf("Failed to create file %v: %v", destFile, err)
-						}
-					} else {
-						log.Fatalf("Request to %v failed: %v", path, err)
+				if _, err := site.Build(paths); err != nil {
+					log.Fatalf("build failed: %v", err)
+				}
+
+				if err := writeFeeds(c, servePath, destinationPath, paths, sourcePaths); err != nil {
+					log.Fatalf("feed generation failed: %v", err)
+				}
+
+				if assetPipeline != nil {
+					buildAssets := assets.New(assets.Config{
+						Patterns:             assetPatterns,
+						Root:                 destinationPath,
+						SubresourceIntegrity: assetSRI,
+						ManifestPath:         filepath.Join(destinationPath, assetManifestName),
+					})
+
+					assetManifest, err := buildAssets.ProcessDirectory(destinationPath)
+
+					if err != nil {
+						log.Fatalf("asset fingerprinting failed: %v", err)
+					}
+
+					if err := assetManifest.WriteTo(filepath.Join(destinationPath, assetManifestName)); err != nil {
+						log.Fatalf("write asset manifest: %v", err)
 					}
 				}
 			} else {
@@ -238,3 +414,255 @@ func main() {
 
 	app.Run(os.Args)
 }
+
+// fdlistenName returns the listener role to look up among adopted
+// systemd listen-fds: the explicit --listen-fd-name flag if set, otherwise
+// the given default role ("http" or "https").
+func fdlistenName(c *cli.Context, role string) string {
+	if name := c.String(`listen-fd-name`); name != `` {
+		return name
+	}
+
+	return role
+}
+
+// serveTLS starts server under HTTPS according to the tls-* flags, starts an
+// optional :80 redirect listener, and installs a signal handler that drains
+// in-flight connections before exiting on SIGINT/SIGTERM. handler is served
+// in place of server directly, so that middleware (e.g. the asset pipeline)
+// wrapped around it still applies.
+func serveTLS(c *cli.Context, server *diecast.Server, handler http.Handler) error {
+	fileConfig, err := tlsutil.LoadConfigFile(c.String(`config`))
+
+	if err != nil {
+		return err
+	}
+
+	mode := fileConfig.Mode
+
+	if c.IsSet(`tls-mode`) || mode == `` {
+		mode = tlsutil.Mode(c.String(`tls-mode`))
+	}
+
+	certFile := fileConfig.CertFile
+
+	if c.IsSet(`tls-cert-file`) || certFile == `` {
+		certFile = c.String(`tls-cert-file`)
+	}
+
+	keyFile := fileConfig.KeyFile
+
+	if c.IsSet(`tls-key-file`) || keyFile == `` {
+		keyFile = c.String(`tls-key-file`)
+	}
+
+	cacheDir := fileConfig.CacheDir
+
+	if c.IsSet(`tls-cache-dir`) || cacheDir == `` {
+		cacheDir = c.String(`tls-cache-dir`)
+	}
+
+	hosts := fileConfig.Hosts
+
+	if c.IsSet(`tls-host`) || len(hosts) == 0 {
+		hosts = c.StringSlice(`tls-host`)
+	}
+
+	email := fileConfig.Email
+
+	if c.IsSet(`tls-email`) || email == `` {
+		email = c.String(`tls-email`)
+	}
+
+	httpRedirect := fileConfig.HTTPRedirect
+
+	if c.IsSet(`tls-http-redirect`) {
+		httpRedirect = c.Bool(`tls-http-redirect`)
+	}
+
+	config := tlsutil.Config{
+		Mode:         mode,
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		CacheDir:     cacheDir,
+		Hosts:        hosts,
+		Email:        email,
+		HTTPRedirect: httpRedirect,
+	}
+
+	var listener net.Listener
+
+	if adopted, ok, ferr := fdlisten.Listener(fdlistenName(c, `https`)); ferr != nil {
+		return ferr
+	} else if ok {
+		wrapped, werr := tlsutil.WrapListener(config, adopted)
+
+		if werr != nil {
+			return werr
+		}
+
+		listener = wrapped
+	} else {
+		opened, oerr := tlsutil.Listener(config, server.Address)
+
+		if oerr != nil {
+			return oerr
+		}
+
+		listener = opened
+	}
+
+	httpServer := &http.Server{
+		Addr:    server.Address,
+		Handler: handler,
+	}
+
+	log.Infof("Starting HTTPS server at https://%s", server.Address)
+
+	go func() {
+		if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	if config.HTTPRedirect {
+		redirectServer := &http.Server{
+			Addr:    `:80`,
+			Handler: tlsutil.RedirectHandler(),
+		}
+
+		go func() {
+			if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Errorf("redirect listener: %v", err)
+			}
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-signals
+		log.Infof("Shutting down, draining connections...")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		if err := httpServer.Shutdown(ctx); err != nil {
+			log.Errorf("graceful shutdown: %v", err)
+		}
+
+		os.Exit(0)
+	}()
+
+	return nil
+}
+
+// writeFeeds generates sitemap.xml and any configured Atom feeds for the
+// given set of built paths and writes them beneath destinationPath. It reads
+// its configuration from the `feeds:` section of the loaded diecast.yml, if
+// any; an absent or empty section results in no files being written.
+func writeFeeds(c *cli.Context, servePath string, destinationPath string, paths []string, sourcePaths map[string]string) error {
+	config, err := feeds.LoadConfigFile(c.String(`config`))
+
+	if err != nil {
+		return err
+	}
+
+	if !config.Sitemap && len(config.Feeds) == 0 {
+		return nil
+	}
+
+	pages := make([]feeds.Page, 0, len(paths))
+
+	for _, path := range paths {
+		sourcePath := sourcePaths[path]
+
+		page := feeds.Page{
+			Path:        path,
+			SourcePath:  sourcePath,
+			LastMod:     time.Now(),
+			ChangeFreq:  feeds.ChangeFreqWeekly,
+			IncludeMap:  true,
+			IncludeFeed: matchesAnyFeed(config.Feeds, sourcePath),
+		}
+
+		if frontMatter, body, ferr := feeds.ReadFrontMatter(filepath.Join(servePath, sourcePath)); ferr == nil {
+			page.FrontMatter = frontMatter
+			page.Content = body
+			page = feeds.FeedSpec{}.ResolveFields(page)
+		} else {
+			log.Debugf("reading front matter for %v: %v", sourcePath, ferr)
+		}
+
+		pages = append(pages, page)
+	}
+
+	if config.Sitemap {
+		documents, err := feeds.GenerateSitemaps(feeds.SitemapConfig{
+			BaseURL:        config.BaseURL,
+			StylesheetPath: config.StylesheetPath,
+		}, pages, config.BaseURL)
+
+		if err != nil {
+			return err
+		}
+
+		for name, data := range documents {
+			if err := ioutil.WriteFile(filepath.Join(destinationPath, name), data, 0644); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, spec := range config.Feeds {
+		data, err := feeds.GenerateAtomFeed(feeds.FeedConfig{
+			Domain:         spec.Domain,
+			StartDate:      spec.StartDate,
+			Specific:       spec.Specific,
+			BaseURL:        config.BaseURL,
+			Title:          spec.Title,
+			Subtitle:       spec.Subtitle,
+			AuthorName:     spec.Author,
+			StylesheetPath: config.StylesheetPath,
+		}, pagesForSpec(pages, spec))
+
+		if err != nil {
+			return err
+		}
+
+		name := spec.Name
+
+		if name == `` {
+			name = `feed.xml`
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(destinationPath, name), data, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func matchesAnyFeed(specs []feeds.FeedSpec, sourcePath string) bool {
+	for _, spec := range specs {
+		if spec.Matches(sourcePath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func pagesForSpec(pages []feeds.Page, spec feeds.FeedSpec) []feeds.Page {
+	out := make([]feeds.Page, 0)
+
+	for _, page := range pages {
+		if spec.Matches(page.SourcePath) {
+			out = append(out, spec.ResolveFields(page))
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,123 @@
+// Package fdlisten adopts file descriptors passed in by systemd socket
+// activation (or `systemd-socket-activate`) as net.Listeners, so diecast can
+// bind privileged ports without running as root and can be restarted by
+// systemd without ever dropping a connection.
+package fdlisten
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor systemd passes to an
+// activated process; fd 0-2 remain stdin/stdout/stderr.
+const listenFDsStart = 3
+
+// Listeners inspects the LISTEN_FDS, LISTEN_PID, and LISTEN_FDNAMES
+// environment variables and, if the current process is the intended
+// recipient, returns a net.Listener for each passed-in file descriptor,
+// keyed by its name (from LISTEN_FDNAMES) or its fd number as a string
+// (e.g. "3") if unnamed. If no listen-fd environment is present, it returns an empty map
+// and a nil error.
+func Listeners() (map[string]net.Listener, error) {
+	pidStr := os.Getenv(`LISTEN_PID`)
+	countStr := os.Getenv(`LISTEN_FDS`)
+
+	if pidStr == `` || countStr == `` {
+		return nil, nil
+	}
+
+	pid, err := strconv.Atoi(pidStr)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_PID: %v", err)
+	}
+
+	if pid != os.Getpid() {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(countStr)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_FDS: %v", err)
+	}
+
+	names := make([]string, count)
+
+	if fdnames := os.Getenv(`LISTEN_FDNAMES`); fdnames != `` {
+		for i, name := range strings.Split(fdnames, `:`) {
+			if i < count {
+				names[i] = name
+			}
+		}
+	}
+
+	listeners := make(map[string]net.Listener, count)
+
+	for i := 0; i < count; i++ {
+		fd := listenFDsStart + i
+		file := os.NewFile(uintptr(fd), fmt.Sprintf("listen-fd-%d", fd))
+
+		listener, err := net.FileListener(file)
+
+		if err != nil {
+			return nil, fmt.Errorf("adopt fd %d: %v", fd, err)
+		}
+
+		name := names[i]
+
+		if name == `` {
+			name = strconv.Itoa(fd)
+		}
+
+		listeners[name] = listener
+	}
+
+	return listeners, nil
+}
+
+// Listener returns the listener matching name from Listeners. When no
+// listener was passed in under that exact name, it falls back to the lone
+// listener present if exactly one was passed in at all — this is the common
+// systemd case of a single anonymous fd with no FileDescriptorName= set, so
+// Listeners will have keyed it by fd number rather than by a role name like
+// "http". It returns ok=false when no matching listener was found.
+func Listener(name string) (net.Listener, bool, error) {
+	listeners, err := Listeners()
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	listener, ok, err := selectListener(listeners, name)
+	return listener, ok, err
+}
+
+// selectListener implements Listener's matching rules against an
+// already-resolved set of listeners, separated out so the fallback logic
+// can be unit tested without real file descriptors.
+func selectListener(listeners map[string]net.Listener, name string) (net.Listener, bool, error) {
+	if len(listeners) == 0 {
+		return nil, false, nil
+	}
+
+	if listener, ok := listeners[name]; ok {
+		return listener, true, nil
+	}
+
+	if len(listeners) == 1 {
+		for _, listener := range listeners {
+			return listener, true, nil
+		}
+	}
+
+	if name == `` {
+		return nil, false, fmt.Errorf("multiple listen-fds passed; specify --listen-fd-name")
+	}
+
+	return nil, false, nil
+}
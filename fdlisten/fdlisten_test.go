@@ -0,0 +1,116 @@
+package fdlisten
+
+import (
+	"net"
+	"os"
+	"testing"
+)
+
+func clearEnv(t *testing.T) {
+	t.Helper()
+
+	for _, key := range []string{`LISTEN_PID`, `LISTEN_FDS`, `LISTEN_FDNAMES`} {
+		if err := os.Unsetenv(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestListenersNoEnv(t *testing.T) {
+	clearEnv(t)
+	defer clearEnv(t)
+
+	listeners, err := Listeners()
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if listeners != nil {
+		t.Fatalf("expected no listeners without LISTEN_FDS/LISTEN_PID, got %v", listeners)
+	}
+}
+
+func TestListenersWrongPID(t *testing.T) {
+	clearEnv(t)
+	defer clearEnv(t)
+
+	os.Setenv(`LISTEN_PID`, `1`)
+	os.Setenv(`LISTEN_FDS`, `1`)
+
+	listeners, err := Listeners()
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if listeners != nil {
+		t.Fatalf("expected no listeners when LISTEN_PID doesn't match our pid, got %v", listeners)
+	}
+}
+
+// TestSelectListenerFallsBackToLoneAnonymousFd exercises the common systemd
+// case: a single fd passed with no FileDescriptorName=, so Listeners keys it
+// by fd number rather than by a role name. A lookup for a role name like
+// "http" must still find it rather than silently falling through to opening
+// a new socket.
+func TestSelectListenerFallsBackToLoneAnonymousFd(t *testing.T) {
+	anonymous := &net.TCPListener{}
+	listeners := map[string]net.Listener{`3`: anonymous}
+
+	listener, ok, err := selectListener(listeners, `http`)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !ok || listener != anonymous {
+		t.Fatalf("expected fallback to the lone anonymous listener, got %v, %v", listener, ok)
+	}
+}
+
+func TestSelectListenerExactNameWins(t *testing.T) {
+	http := &net.TCPListener{}
+	https := &net.TCPListener{}
+	listeners := map[string]net.Listener{`http`: http, `https`: https}
+
+	listener, ok, err := selectListener(listeners, `https`)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !ok || listener != https {
+		t.Fatalf("expected exact-name match, got %v, %v", listener, ok)
+	}
+}
+
+func TestSelectListenerAmbiguousWithoutName(t *testing.T) {
+	listeners := map[string]net.Listener{
+		`3`: &net.TCPListener{},
+		`4`: &net.TCPListener{},
+	}
+
+	_, ok, err := selectListener(listeners, ``)
+
+	if err == nil || ok {
+		t.Fatal("expected an error when multiple anonymous fds are passed without a name")
+	}
+}
+
+func TestSelectListenerNoMatch(t *testing.T) {
+	listeners := map[string]net.Listener{
+		`http`:  &net.TCPListener{},
+		`https`: &net.TCPListener{},
+	}
+
+	_, ok, err := selectListener(listeners, `metrics`)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if ok {
+		t.Fatal("expected no match for an unknown name among multiple named listeners")
+	}
+}
@@ -0,0 +1,540 @@
+// Package feeds generates sitemap.xml documents and Atom 1.0 feeds from a
+// set of URLs and their associated front-matter, for use both during
+// `--build-site` and from the live server.
+package feeds
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+// maxURLsPerSitemap is the upper bound on <url> entries in a single sitemap
+// document before it must be split into a sitemap index, per the sitemaps.org
+// protocol.
+const maxURLsPerSitemap = 50000
+
+// ChangeFreq is one of the enumerated <changefreq> values from the sitemap
+// protocol.
+type ChangeFreq string
+
+const (
+	ChangeFreqAlways  ChangeFreq = `always`
+	ChangeFreqHourly  ChangeFreq = `hourly`
+	ChangeFreqDaily   ChangeFreq = `daily`
+	ChangeFreqWeekly  ChangeFreq = `weekly`
+	ChangeFreqMonthly ChangeFreq = `monthly`
+	ChangeFreqYearly  ChangeFreq = `yearly`
+	ChangeFreqNever   ChangeFreq = `never`
+)
+
+// Page describes a single rendered URL, with the subset of front-matter
+// fields that feeds and sitemaps care about.
+type Page struct {
+	// Path is the public URL path this page was served at.
+	Path string
+
+	// SourcePath is the path to the source file this page was rendered
+	// from, relative to the site root (e.g. "posts/foo.md"). FeedSpec
+	// matches against this, not Path, since a feed's SourceGlob describes
+	// the source layout and may have no relation to the URL layout.
+	SourcePath string
+
+	// FrontMatter holds the page's raw front-matter fields, keyed by their
+	// name in the source file. FeedSpec.ResolveFields turns this into the
+	// Title/Summary/Author/Published/Updated/Priority/LastMod/ChangeFreq
+	// fields below, consulting FieldMap for any renamed keys.
+	FrontMatter map[string]interface{}
+
+	LastMod     time.Time
+	ChangeFreq  ChangeFreq
+	Priority    float64
+	Title       string
+	Summary     string
+	Content     string
+	Author      string
+	Published   time.Time
+	Updated     time.Time
+	IncludeFeed bool
+	IncludeMap  bool
+}
+
+// ReadFrontMatter reads a Jekyll/Hugo-style YAML front-matter block
+// delimited by "---" lines from the top of sourcePath, returning the parsed
+// fields and the remaining body text. A file that doesn't begin with a
+// "---" line has no front-matter block; it returns an empty map and the
+// entire file as the body.
+func ReadFrontMatter(sourcePath string) (map[string]interface{}, string, error) {
+	data, err := ioutil.ReadFile(sourcePath)
+
+	if err != nil {
+		return nil, ``, err
+	}
+
+	const delim = "---\n"
+
+	if !bytes.HasPrefix(data, []byte(delim)) {
+		return make(map[string]interface{}), string(data), nil
+	}
+
+	rest := data[len(delim):]
+	end := bytes.Index(rest, []byte("\n"+delim))
+
+	if end < 0 {
+		return make(map[string]interface{}), string(data), nil
+	}
+
+	raw := rest[:end+1]
+	body := rest[end+1+len(delim):]
+	frontMatter := make(map[string]interface{})
+
+	if err := yaml.Unmarshal(raw, &frontMatter); err != nil {
+		return nil, ``, err
+	}
+
+	return frontMatter, string(bytes.TrimSpace(body)), nil
+}
+
+// SitemapConfig controls how a sitemap document is generated.
+type SitemapConfig struct {
+	// BaseURL is prepended to each page's path (e.g. "https://example.com").
+	BaseURL string
+
+	// StylesheetPath, if set, is emitted as an <?xml-stylesheet?> processing
+	// instruction so the raw sitemap can be browsed in a web browser.
+	StylesheetPath string
+}
+
+// FeedConfig describes a single Atom feed to be generated from a set of
+// pages.
+type FeedConfig struct {
+	// Domain, StartDate, and Specific make up the feed's tag URI, of the
+	// form "tag:<domain>,<start-date>:<specific>" per RFC 4151.
+	Domain     string
+	StartDate  string
+	Specific   string
+	BaseURL    string
+	Title      string
+	Subtitle   string
+	AuthorName string
+	AuthorURI  string
+
+	// StylesheetPath, if set, is emitted as an <?xml-stylesheet?> processing
+	// instruction.
+	StylesheetPath string
+}
+
+// TagURI builds the feed's RFC 4151 tag URI from its configured parts.
+func (self FeedConfig) TagURI() string {
+	return fmt.Sprintf("tag:%s,%s:%s", self.Domain, self.StartDate, self.Specific)
+}
+
+// joinURL concatenates a base URL and a path element with exactly one slash
+// between them. Unlike path.Join, it doesn't treat base as a filesystem
+// path, so it won't collapse the "//" in "https://example.com" down to a
+// single slash.
+func joinURL(base string, elem string) string {
+	return strings.TrimRight(base, `/`) + `/` + strings.TrimLeft(elem, `/`)
+}
+
+// urlsetEntry is a single <url> element in a sitemap document.
+type urlsetEntry struct {
+	XMLName    xml.Name `xml:"url"`
+	Loc        string   `xml:"loc"`
+	LastMod    string   `xml:"lastmod,omitempty"`
+	ChangeFreq string   `xml:"changefreq,omitempty"`
+	Priority   string   `xml:"priority,omitempty"`
+}
+
+type urlset struct {
+	XMLName xml.Name      `xml:"urlset"`
+	Xmlns   string        `xml:"xmlns,attr"`
+	URLs    []urlsetEntry `xml:"url"`
+}
+
+type sitemapindexEntry struct {
+	XMLName xml.Name `xml:"sitemap"`
+	Loc     string   `xml:"loc"`
+}
+
+type sitemapindex struct {
+	XMLName  xml.Name            `xml:"sitemapindex"`
+	Xmlns    string              `xml:"xmlns,attr"`
+	Sitemaps []sitemapindexEntry `xml:"sitemap"`
+}
+
+// GenerateSitemaps renders one or more sitemap documents from pages,
+// splitting into a sitemap index when there are more than 50,000 URLs.
+// indexURL is the base URL the split sitemap documents are published under;
+// the index lists each one as indexURL joined with its filename. It is only
+// used when a split is necessary. The returned map is keyed by the
+// filename each document should be written as (e.g. "sitemap.xml",
+// "sitemap-2.xml", "sitemap-index.xml").
+func GenerateSitemaps(config SitemapConfig, pages []Page, indexURL string) (map[string][]byte, error) {
+	sortable := make([]Page, 0, len(pages))
+
+	for _, page := range pages {
+		if page.IncludeMap {
+			sortable = append(sortable, page)
+		}
+	}
+
+	sort.Slice(sortable, func(i int, j int) bool {
+		return sortable[i].Path < sortable[j].Path
+	})
+
+	out := make(map[string][]byte)
+
+	if len(sortable) <= maxURLsPerSitemap {
+		data, err := renderSitemap(config, sortable)
+
+		if err != nil {
+			return nil, err
+		}
+
+		out[`sitemap.xml`] = data
+		return out, nil
+	}
+
+	var index sitemapindex
+	index.Xmlns = `http://www.sitemaps.org/schemas/sitemap/0.9`
+
+	for i := 0; i*maxURLsPerSitemap < len(sortable); i++ {
+		lo := i * maxURLsPerSitemap
+		hi := lo + maxURLsPerSitemap
+
+		if hi > len(sortable) {
+			hi = len(sortable)
+		}
+
+		name := fmt.Sprintf("sitemap-%d.xml", i+1)
+		data, err := renderSitemap(config, sortable[lo:hi])
+
+		if err != nil {
+			return nil, err
+		}
+
+		out[name] = data
+		index.Sitemaps = append(index.Sitemaps, sitemapindexEntry{
+			Loc: joinURL(indexURL, name),
+		})
+	}
+
+	indexData, err := xml.MarshalIndent(index, ``, `  `)
+
+	if err != nil {
+		return nil, err
+	}
+
+	out[`sitemap-index.xml`] = append(withStylesheet(config.StylesheetPath), indexData...)
+
+	return out, nil
+}
+
+func renderSitemap(config SitemapConfig, pages []Page) ([]byte, error) {
+	set := urlset{
+		Xmlns: `http://www.sitemaps.org/schemas/sitemap/0.9`,
+	}
+
+	for _, page := range pages {
+		entry := urlsetEntry{
+			Loc: joinURL(config.BaseURL, page.Path),
+		}
+
+		if !page.LastMod.IsZero() {
+			entry.LastMod = page.LastMod.Format(time.RFC3339)
+		}
+
+		if page.ChangeFreq != `` {
+			entry.ChangeFreq = string(page.ChangeFreq)
+		}
+
+		if page.Priority > 0 {
+			entry.Priority = fmt.Sprintf("%.1f", page.Priority)
+		}
+
+		set.URLs = append(set.URLs, entry)
+	}
+
+	data, err := xml.MarshalIndent(set, ``, `  `)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(withStylesheet(config.StylesheetPath), data...), nil
+}
+
+// atomEntry is a single <entry> element in an Atom feed.
+type atomEntry struct {
+	XMLName   xml.Name     `xml:"entry"`
+	ID        string       `xml:"id"`
+	Title     string       `xml:"title"`
+	Updated   string       `xml:"updated"`
+	Published string       `xml:"published,omitempty"`
+	Summary   string       `xml:"summary,omitempty"`
+	Content   *atomContent `xml:"content,omitempty"`
+	Link      atomLink     `xml:"link"`
+	Author    *atomAuthor  `xml:"author,omitempty"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"feed"`
+	Xmlns    string      `xml:"xmlns,attr"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Updated  string      `xml:"updated"`
+	Link     []atomLink  `xml:"link"`
+	Author   *atomAuthor `xml:"author,omitempty"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+// GenerateAtomFeed renders a single Atom 1.0 feed document from pages,
+// selecting only those pages with IncludeFeed set.
+func GenerateAtomFeed(config FeedConfig, pages []Page) ([]byte, error) {
+	sortable := make([]Page, 0, len(pages))
+
+	for _, page := range pages {
+		if page.IncludeFeed {
+			sortable = append(sortable, page)
+		}
+	}
+
+	sort.Slice(sortable, func(i int, j int) bool {
+		return sortable[i].Updated.After(sortable[j].Updated)
+	})
+
+	feed := atomFeed{
+		Xmlns: `http://www.w3.org/2005/Atom`,
+		ID:    config.TagURI(),
+		Title: config.Title,
+		Link: []atomLink{
+			{Href: config.BaseURL, Rel: `alternate`},
+			{Href: joinURL(config.BaseURL, `feed.xml`), Rel: `self`},
+		},
+	}
+
+	if config.Subtitle != `` {
+		feed.Subtitle = config.Subtitle
+	}
+
+	if config.AuthorName != `` {
+		feed.Author = &atomAuthor{
+			Name: config.AuthorName,
+			URI:  config.AuthorURI,
+		}
+	}
+
+	var latest time.Time
+
+	for _, page := range sortable {
+		entryID := fmt.Sprintf("%s:%s", config.TagURI(), page.Path)
+
+		entry := atomEntry{
+			ID:      entryID,
+			Title:   page.Title,
+			Updated: page.Updated.Format(time.RFC3339),
+			Summary: page.Summary,
+			Link: atomLink{
+				Href: joinURL(config.BaseURL, page.Path),
+				Rel:  `alternate`,
+			},
+		}
+
+		if !page.Published.IsZero() {
+			entry.Published = page.Published.Format(time.RFC3339)
+		}
+
+		if page.Content != `` {
+			entry.Content = &atomContent{
+				Type: `html`,
+				Body: page.Content,
+			}
+		}
+
+		if page.Author != `` {
+			entry.Author = &atomAuthor{
+				Name: page.Author,
+			}
+		}
+
+		if page.Updated.After(latest) {
+			latest = page.Updated
+		}
+
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	if latest.IsZero() {
+		latest = time.Now()
+	}
+
+	feed.Updated = latest.Format(time.RFC3339)
+
+	data, err := xml.MarshalIndent(feed, ``, `  `)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(withStylesheet(config.StylesheetPath), data...), nil
+}
+
+// FeedSpec describes a single configured feed, as loaded from the `feeds:`
+// block of diecast.yml.
+type FeedSpec struct {
+	Name       string            `json:"name"`
+	SourceGlob string            `json:"source"`
+	Domain     string            `json:"domain"`
+	StartDate  string            `json:"start_date"`
+	Specific   string            `json:"specific"`
+	Title      string            `json:"title"`
+	Subtitle   string            `json:"subtitle"`
+	Author     string            `json:"author"`
+	FieldMap   map[string]string `json:"field_map"`
+}
+
+// Config is the top-level `feeds:` section of diecast.yml.
+type Config struct {
+	BaseURL        string     `json:"base_url"`
+	StylesheetPath string     `json:"stylesheet"`
+	Sitemap        bool       `json:"sitemap"`
+	Feeds          []FeedSpec `json:"feeds"`
+}
+
+// rootConfig mirrors the shape of diecast.yml well enough to pull out the
+// `feeds:` section without needing the rest of the document.
+type rootConfig struct {
+	Feeds Config `json:"feeds"`
+}
+
+// LoadConfigFile reads the `feeds:` section out of a diecast.yml-formatted
+// file. A missing or empty feeds section is not an error.
+func LoadConfigFile(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return &Config{}, nil
+	}
+
+	var root rootConfig
+
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	return &root.Feeds, nil
+}
+
+// Matches reports whether sourcePath (a Page.SourcePath, e.g. "posts/foo.md")
+// was produced by the source glob configured for this feed spec. It matches
+// against the source file's path, not the page's rendered URL, since
+// SourceGlob describes the source layout.
+func (self FeedSpec) Matches(sourcePath string) bool {
+	ok, err := filepath.Match(self.SourceGlob, sourcePath)
+	return err == nil && ok
+}
+
+// ResolveFields returns a copy of page with Title, Summary, Author,
+// Published, Updated, Priority, LastMod, and ChangeFreq populated from
+// page.FrontMatter, consulting FieldMap for any field whose front-matter key
+// differs from its default name. Pages with no Updated front-matter fall
+// back to LastMod, so that Atom feeds don't end up with a zero-valued
+// <updated>.
+func (self FeedSpec) ResolveFields(page Page) Page {
+	field := func(name string) (interface{}, bool) {
+		key := name
+
+		if mapped, ok := self.FieldMap[name]; ok {
+			key = mapped
+		}
+
+		v, ok := page.FrontMatter[key]
+		return v, ok
+	}
+
+	if v, ok := field(`title`); ok {
+		page.Title, _ = v.(string)
+	}
+
+	if v, ok := field(`summary`); ok {
+		page.Summary, _ = v.(string)
+	}
+
+	if v, ok := field(`author`); ok {
+		page.Author, _ = v.(string)
+	}
+
+	if v, ok := field(`priority`); ok {
+		if f, ok := v.(float64); ok {
+			page.Priority = f
+		}
+	}
+
+	if v, ok := field(`lastmod`); ok {
+		if s, ok := v.(string); ok {
+			if t, err := time.Parse(time.RFC3339, s); err == nil {
+				page.LastMod = t
+			}
+		}
+	}
+
+	if v, ok := field(`changefreq`); ok {
+		if s, ok := v.(string); ok {
+			page.ChangeFreq = ChangeFreq(s)
+		}
+	}
+
+	if v, ok := field(`published`); ok {
+		if s, ok := v.(string); ok {
+			if t, err := time.Parse(time.RFC3339, s); err == nil {
+				page.Published = t
+			}
+		}
+	}
+
+	if v, ok := field(`updated`); ok {
+		if s, ok := v.(string); ok {
+			if t, err := time.Parse(time.RFC3339, s); err == nil {
+				page.Updated = t
+			}
+		}
+	} else if page.Updated.IsZero() {
+		page.Updated = page.LastMod
+	}
+
+	return page
+}
+
+func withStylesheet(stylesheetPath string) []byte {
+	header := []byte(xml.Header)
+
+	if stylesheetPath != `` {
+		header = append(header, []byte(fmt.Sprintf("<?xml-stylesheet type=\"text/xsl\" href=%q?>\n", stylesheetPath))...)
+	}
+
+	return header
+}
@@ -0,0 +1,158 @@
+package feeds
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJoinURL(t *testing.T) {
+	cases := []struct {
+		base string
+		elem string
+		want string
+	}{
+		{`https://example.com`, `sitemap.xml`, `https://example.com/sitemap.xml`},
+		{`https://example.com/`, `/sitemap.xml`, `https://example.com/sitemap.xml`},
+		{`https://example.com`, `/posts/foo`, `https://example.com/posts/foo`},
+	}
+
+	for _, c := range cases {
+		if got := joinURL(c.base, c.elem); got != c.want {
+			t.Fatalf("joinURL(%q, %q) = %q, want %q", c.base, c.elem, got, c.want)
+		}
+	}
+}
+
+func TestReadFrontMatter(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `feeds-test-`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, `post.md`)
+	contents := "---\ntitle: Hello World\nauthor: Alice\n---\n# Hello\n\nBody text.\n"
+
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	frontMatter, body, err := ReadFrontMatter(path)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if frontMatter[`title`] != `Hello World` {
+		t.Fatalf("expected title from front matter, got %v", frontMatter[`title`])
+	}
+
+	if !strings.Contains(body, `Body text.`) {
+		t.Fatalf("expected body to contain page content, got %q", body)
+	}
+}
+
+func TestReadFrontMatterNoBlock(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `feeds-test-`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, `post.md`)
+
+	if err := ioutil.WriteFile(path, []byte("# Hello\n\nNo front matter here.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	frontMatter, body, err := ReadFrontMatter(path)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(frontMatter) != 0 {
+		t.Fatalf("expected no front-matter fields, got %v", frontMatter)
+	}
+
+	if !strings.Contains(body, `No front matter here.`) {
+		t.Fatalf("expected the whole file as body, got %q", body)
+	}
+}
+
+func TestFeedSpecMatchesSourcePathNotURL(t *testing.T) {
+	spec := FeedSpec{SourceGlob: `posts/*.md`}
+
+	if !spec.Matches(`posts/hello.md`) {
+		t.Fatal("expected spec to match its source glob")
+	}
+
+	if spec.Matches(`/posts/hello`) {
+		t.Fatal("expected spec not to match a rendered URL path")
+	}
+}
+
+func TestFeedSpecResolveFields(t *testing.T) {
+	spec := FeedSpec{
+		FieldMap: map[string]string{
+			`summary`: `desc`,
+		},
+	}
+
+	lastMod := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	page := Page{
+		LastMod: lastMod,
+		FrontMatter: map[string]interface{}{
+			`title`: `Hello`,
+			`desc`:  `A summary`,
+		},
+	}
+
+	resolved := spec.ResolveFields(page)
+
+	if resolved.Title != `Hello` {
+		t.Fatalf("expected title to be resolved, got %q", resolved.Title)
+	}
+
+	if resolved.Summary != `A summary` {
+		t.Fatalf("expected summary to be resolved via field_map, got %q", resolved.Summary)
+	}
+
+	if !resolved.Updated.Equal(lastMod) {
+		t.Fatalf("expected Updated to fall back to LastMod, got %v", resolved.Updated)
+	}
+}
+
+func TestFeedSpecResolveFieldsLastModAndChangeFreq(t *testing.T) {
+	spec := FeedSpec{}
+
+	page := Page{
+		LastMod:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		ChangeFreq: ChangeFreqWeekly,
+		FrontMatter: map[string]interface{}{
+			`lastmod`:    `2026-03-04T05:06:07Z`,
+			`changefreq`: `daily`,
+		},
+	}
+
+	resolved := spec.ResolveFields(page)
+
+	want := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	if !resolved.LastMod.Equal(want) {
+		t.Fatalf("expected LastMod to be resolved from front matter, got %v", resolved.LastMod)
+	}
+
+	if resolved.ChangeFreq != ChangeFreqDaily {
+		t.Fatalf("expected ChangeFreq to be resolved from front matter, got %v", resolved.ChangeFreq)
+	}
+}
@@ -0,0 +1,244 @@
+// Package tlsutil builds *tls.Config values and net.Listeners for the three
+// TLS modes diecast supports: static certificate files, ACME/Let's Encrypt
+// via autocert, and an in-memory self-signed certificate for local
+// development.
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Mode selects which of the three supported TLS configurations to use.
+type Mode string
+
+const (
+	// ModeStatic loads a certificate and key from disk.
+	ModeStatic Mode = `static`
+
+	// ModeAutocert obtains and renews certificates from an ACME CA (e.g.
+	// Let's Encrypt) on demand.
+	ModeAutocert Mode = `autocert`
+
+	// ModeSelfSigned mints an in-memory, self-signed certificate suitable
+	// only for local development.
+	ModeSelfSigned Mode = `self_signed`
+)
+
+// Config is the `tls:` block of diecast.yml.
+type Config struct {
+	Enabled bool `json:"enabled"`
+	Mode    Mode `json:"mode"`
+
+	// CertFile and KeyFile are used when Mode is ModeStatic.
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+
+	// CacheDir, Hosts, and Email are used when Mode is ModeAutocert.
+	CacheDir string   `json:"cache_dir"`
+	Hosts    []string `json:"hosts"`
+	Email    string   `json:"email"`
+
+	// HTTPRedirect, when true, starts a second listener on :80 that
+	// redirects all requests to https.
+	HTTPRedirect bool `json:"http_redirect"`
+}
+
+type rootConfig struct {
+	TLS Config `json:"tls"`
+}
+
+// LoadConfigFile reads the `tls:` section out of a diecast.yml-formatted
+// file. A missing file or empty section is not an error.
+func LoadConfigFile(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return &Config{}, nil
+	}
+
+	var root rootConfig
+
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+
+	return &root.TLS, nil
+}
+
+// curated from Mozilla's "intermediate" TLS compatibility list.
+var preferredCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+}
+
+// baseConfig returns a *tls.Config with diecast's default minimum version,
+// curated cipher suites, and HTTP/2 advertised via ALPN.
+func baseConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:               tls.VersionTLS12,
+		CipherSuites:             preferredCipherSuites,
+		PreferServerCipherSuites: true,
+		CurvePreferences: []tls.CurveID{
+			tls.X25519,
+			tls.CurveP256,
+		},
+		NextProtos: []string{`h2`, `http/1.1`},
+	}
+}
+
+// Listener returns a net.Listener that serves TLS according to config,
+// wrapping an underlying listener bound to address.
+func Listener(config Config, address string) (net.Listener, error) {
+	inner, err := net.Listen(`tcp`, address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := buildTLSConfig(config)
+
+	if err != nil {
+		inner.Close()
+		return nil, err
+	}
+
+	return tls.NewListener(inner, tlsConfig), nil
+}
+
+// WrapListener wraps an already-open net.Listener (e.g. one adopted from a
+// systemd listen-fd) in a TLS listener built from config, instead of opening
+// a new socket.
+func WrapListener(config Config, inner net.Listener) (net.Listener, error) {
+	tlsConfig, err := buildTLSConfig(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return tls.NewListener(inner, tlsConfig), nil
+}
+
+func buildTLSConfig(config Config) (*tls.Config, error) {
+	switch config.Mode {
+	case ModeAutocert:
+		return autocertConfig(config)
+	case ModeSelfSigned:
+		return selfSignedConfig()
+	default:
+		return staticConfig(config)
+	}
+}
+
+func staticConfig(config Config) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+
+	if err != nil {
+		return nil, fmt.Errorf("load certificate: %v", err)
+	}
+
+	tlsConfig := baseConfig()
+	tlsConfig.Certificates = []tls.Certificate{cert}
+
+	return tlsConfig, nil
+}
+
+// autocertConfig builds a *tls.Config backed by an autocert.Manager, which
+// persists issued certificates beneath CacheDir so that restarts don't
+// trigger re-issuance against the ACME rate limits.
+func autocertConfig(config Config) (*tls.Config, error) {
+	if len(config.Hosts) == 0 {
+		return nil, fmt.Errorf("autocert mode requires at least one host")
+	}
+
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(config.Hosts...),
+		Email:      config.Email,
+	}
+
+	if config.CacheDir != `` {
+		manager.Cache = autocert.DirCache(config.CacheDir)
+	}
+
+	tlsConfig := manager.TLSConfig()
+	tlsConfig.MinVersion = tls.VersionTLS12
+	tlsConfig.CipherSuites = preferredCipherSuites
+
+	return tlsConfig, nil
+}
+
+// selfSignedConfig mints a throwaway ECDSA certificate for "localhost",
+// valid for 24 hours, entirely in memory. It is intended for local
+// development only.
+func selfSignedConfig() (*tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{`diecast self-signed`},
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{`localhost`},
+		IPAddresses:           []net.IP{net.ParseIP(`127.0.0.1`), net.ParseIP(`::1`)},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cert := tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}
+
+	tlsConfig := baseConfig()
+	tlsConfig.Certificates = []tls.Certificate{cert}
+
+	return tlsConfig, nil
+}
+
+// RedirectHandler returns an http.Handler that 301-redirects every request
+// to the https equivalent of its URL, for use behind the :80 listener
+// started when Config.HTTPRedirect is set.
+func RedirectHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := `https://` + r.Host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
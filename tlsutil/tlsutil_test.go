@@ -0,0 +1,59 @@
+package tlsutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigFileMissing(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `tlsutil-test-`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	config, err := LoadConfigFile(filepath.Join(dir, `diecast.yml`))
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if config.Mode != `` {
+		t.Fatalf("expected an empty config, got %+v", config)
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir(``, `tlsutil-test-`)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, `diecast.yml`)
+	contents := "tls:\n  mode: autocert\n  hosts:\n    - example.com\n  email: admin@example.com\n"
+
+	if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfigFile(path)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if config.Mode != ModeAutocert {
+		t.Fatalf("expected autocert mode, got %v", config.Mode)
+	}
+
+	if len(config.Hosts) != 1 || config.Hosts[0] != `example.com` {
+		t.Fatalf("expected hosts from yaml, got %v", config.Hosts)
+	}
+}